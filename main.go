@@ -1,33 +1,48 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/robfig/cron/v3"
 )
 
 // Config 存储用户配置
 type Config struct {
 	CronSpec      string  `json:"cron_spec"`      // Cron 表达式
-	ZoneID        string  `json:"zone_id"`        // Cloudflare Zone ID
+	ZoneID        string  `json:"zone_id"`        // Cloudflare Zone ID (DNSPod 模式下存放根域名)
 	APIKey        string  `json:"api_key"`        // Global API Key
 	Email         string  `json:"email"`          // Cloudflare 邮箱
 	Domains       string  `json:"domains"`        // 域名列表
-	
+
+	// 认证方式
+	AuthMode string `json:"auth_mode"` // "global_key" | "token", 默认 "global_key"
+	APIToken string `json:"api_token"` // Cloudflare API Token (Scoped, Zone.DNS:Edit)
+
+	// DNS 服务商
+	Provider    string `json:"provider"`     // "cloudflare" | "dnspod", 默认 "cloudflare"
+	DNSPodID    string `json:"dnspod_id"`    // DNSPod API Token ID
+	DNSPodToken string `json:"dnspod_token"` // DNSPod API Token
+
 	// 测速参数
 	DownloadURL   string  `json:"download_url"`   // 测速地址
 	TestCount     int     `json:"test_count"`     // -dn 测速数量
@@ -39,6 +54,18 @@ type Config struct {
 	IPType        string  `json:"ip_type"`        // "v4", "v6", "both"
 	Colo          string  `json:"colo"`           // 地区码
 	EnableHTTPing bool    `json:"enable_httping"` // HTTPing
+
+	// TLS/SNI 校验 (防止反代扫描出的 IP 实际上不是 Cloudflare 节点)
+	EnableTLSValidation bool     `json:"enable_tls_validation"` // 是否启用 TLS 校验
+	TLSExpectedSANs     []string `json:"tls_expected_sans"`     // 允许的证书 SAN / 颁发者 CN
+	TLSTimeoutSec       int      `json:"tls_timeout_sec"`       // 单个 IP 的 TLS 握手超时(秒)
+
+	// 变更检测 (跳过短时间内无意义的重复测速)
+	SkipUnchanged    bool   `json:"skip_unchanged"`     // 未发生变化时是否跳过本次运行
+	MinRerunInterval string `json:"min_rerun_interval"` // 两次运行的最小间隔, 如 "30m"
+
+	// 历史记录
+	HistoryKeepDays int `json:"history_keep_days"` // 历史记录保留天数, <=0 表示不清理
 }
 
 var (
@@ -49,7 +76,10 @@ var (
 	ip4File    = filepath.Join(dataDir, "ip.txt")
 	ip6File    = filepath.Join(dataDir, "ipv6.txt")
 	resultFile = filepath.Join(dataDir, "result.csv")
-	
+	lastIPsFile = filepath.Join(dataDir, "last_ips.json")
+	logJSONFile = filepath.Join(dataDir, "app.jsonl")
+	historyFile = filepath.Join(dataDir, "history.jsonl")
+
 	config     Config
 	mutex      sync.Mutex // 配置锁
 	runMutex   sync.Mutex // 运行锁
@@ -66,10 +96,21 @@ func main() {
 	if _, err := os.Stat(logFile); os.IsNotExist(err) {
 		os.WriteFile(logFile, []byte("服务初始化成功...\n"), 0644)
 	}
+	if _, err := os.Stat(logJSONFile); os.IsNotExist(err) {
+		os.WriteFile(logJSONFile, nil, 0644)
+	}
 
 	// 2. 加载配置
 	loadConfig()
 
+	// 2.2 按保留天数清理历史记录
+	trimHistory(config.HistoryKeepDays)
+
+	// 2.5 Cloudflare Token 模式下启动自检, 提前暴露权限/作用域问题
+	if config.Provider != "dnspod" && config.AuthMode == "token" && config.APIToken != "" {
+		go verifyAPIToken()
+	}
+
 	// 3. 启动定时任务
 	cronRunner = cron.New()
 	updateCron()
@@ -81,7 +122,10 @@ func main() {
 	http.HandleFunc("/api/upload", handleUpload)
 	http.HandleFunc("/api/run", handleRunNow)
 	http.HandleFunc("/api/logs", handleLogs)
+	http.HandleFunc("/api/logs/stream", handleLogsStream)
 	http.HandleFunc("/api/status", handleStatus)
+	http.HandleFunc("/api/history", handleHistory)
+	http.HandleFunc("/api/history/metrics", handleHistoryMetrics)
 
 	writeLog(fmt.Sprintf("Web server running on :8080 (Version: %s)", "1.3.1"))
 	log.Println("Web server started on :8080")
@@ -123,10 +167,10 @@ func runSpeedTestAndUpdateDNS() {
 	}
 
 	// 2. 预检 API 和 Zone 信息 (修复域名双重后缀的关键步骤)
-	zoneName := ""
-	if config.ZoneID != "" && config.APIKey != "" {
-		var err error
-		zoneName, err = fetchZoneName()
+	var provider DNSProvider
+	if hasDNSCredentials() {
+		provider = newDNSProvider()
+		zoneName, err := provider.ZoneName()
 		if err != nil {
 			writeLog(fmt.Sprintf("⚠️ 获取 Zone 信息失败 (可能导致域名解析后缀重复): %v", err))
 		} else {
@@ -141,6 +185,12 @@ func runSpeedTestAndUpdateDNS() {
 		return
 	}
 
+	// 3.5 变更检测: 距上次运行太近且线上记录没有漂移时，跳过本次测速
+	if reason := skipReason(provider, domainList); reason != "" {
+		writeLog(fmt.Sprintf("⏭️ 跳过本次测速: %s", reason))
+		return
+	}
+
 	// 计算所需 IP 数量
 	requiredCount := config.MaxResult
 	if requiredCount <= 0 { requiredCount = 10 }
@@ -189,8 +239,8 @@ func runSpeedTestAndUpdateDNS() {
 		return
 	}
 
-	go io.Copy(getLogWriter(), stdoutPipe)
-	go io.Copy(getLogWriter(), stderrPipe)
+	go streamCfstOutput(stdoutPipe)
+	go streamCfstOutput(stderrPipe)
 
 	if err := cmd.Wait(); err != nil {
 		writeLog(fmt.Sprintf("⚠️ 测速结束 (Exit Code: %v) - 请检查上方日志是否有报错", err))
@@ -204,72 +254,171 @@ func runSpeedTestAndUpdateDNS() {
 	}
 	writeLog(fmt.Sprintf("✅ 获取到 %d 个优选 IP", len(ips)))
 
+	// 5.5 TLS/SNI 校验 (剔除反代扫描出的、实际并非 Cloudflare 节点的 IP)
+	if config.EnableTLSValidation {
+		verified := filterCloudflareIPs(ips, port)
+		if len(verified) == 0 {
+			writeLog("❌ 失败: 所有 IP 均未通过 TLS 校验")
+			return
+		}
+		writeLog(fmt.Sprintf("🔒 TLS 校验通过 %d/%d 个 IP", len(verified), len(ips)))
+		ips = verified
+	}
+
 	// 6. 更新 DNS
-	updateDNSStrategy(domainList, ips, zoneName)
-	
+	published := updateDNSStrategy(provider, domainList, ips)
+	if len(published) > 0 {
+		saveLastRunState(published)
+		appendHistoryEntry(published, resultFile)
+	}
+
 	writeLog("=== 任务完成 ===")
 }
 
-func updateDNSStrategy(domains []string, ips []string, zoneName string) {
-	if config.ZoneID == "" || config.APIKey == "" {
-		writeLog("⚠️ 跳过 DNS 更新: API 配置缺失")
-		return
+// updateDNSStrategy 下发优选 IP 并返回实际发布到每个域名的 IP 列表 (供变更检测留档)
+func updateDNSStrategy(provider DNSProvider, domains []string, ips []string) map[string][]string {
+	if provider == nil {
+		writeLogStage("dns", "warn", "⚠️ 跳过 DNS 更新: API 配置缺失")
+		return nil
 	}
 
+	published := map[string][]string{}
+
 	// 单域名负载均衡
 	if len(domains) == 1 {
 		domain := domains[0]
 		limit := config.MaxResult
 		if limit <= 0 { limit = 10 }
 		if len(ips) > limit { ips = ips[:limit] }
-		
-		writeLog(fmt.Sprintf("📡 更新域名 [%s] (负载均衡, IP数: %d)...", domain, len(ips)))
-		updateCloudflareDNS(domain, ips, zoneName)
-		return
+
+		writeLogStage("dns", "info", fmt.Sprintf("📡 更新域名 [%s] (负载均衡, IP数: %d)...", domain, len(ips)))
+		if updateProviderDNS(provider, domain, ips) {
+			published[domain] = ips
+		}
+		return published
 	}
 
 	// 多域名分发
-	writeLog(fmt.Sprintf("📡 更新 %d 个域名 (1对1 分发)...", len(domains)))
+	writeLogStage("dns", "info", fmt.Sprintf("📡 更新 %d 个域名 (1对1 分发)...", len(domains)))
 	for i, domain := range domains {
 		if i >= len(ips) {
-			writeLog(fmt.Sprintf("⚠️ IP 不足，跳过 [%s]", domain))
+			writeLogStage("dns", "warn", fmt.Sprintf("⚠️ IP 不足，跳过 [%s]", domain))
 			break
 		}
-		writeLog(fmt.Sprintf(" -> [%s] 解析至 [%s]", domain, ips[i]))
-		updateCloudflareDNS(domain, []string{ips[i]}, zoneName)
+		writeLogStage("dns", "info", fmt.Sprintf(" -> [%s] 解析至 [%s]", domain, ips[i]))
+		if updateProviderDNS(provider, domain, []string{ips[i]}) {
+			published[domain] = []string{ips[i]}
+		}
 	}
+	return published
 }
 
-func updateCloudflareDNS(domain string, newIPs []string, zoneName string) {
-	// 1. 获取现有记录 (搜索时使用完整域名)
-	records, err := getDNSRecords(domain)
+// updateProviderDNS 对单个域名求出目标 IP 与服务商现有记录的差集, 再把变更集交给
+// provider.Apply 下发 —— 具体是分开调用还是走批量接口由各 Provider 实现自行决定。
+// 返回值表示该域名的记录最终是否与目标 IP 一致 (无需变更或变更成功)
+func updateProviderDNS(provider DNSProvider, domain string, newIPs []string) bool {
+	zoneName, err := provider.ZoneName()
 	if err != nil {
-		writeLog(fmt.Sprintf("❌ 获取记录失败 [%s]: %v", domain, err))
-		return
+		writeLogStage("dns", "warn", fmt.Sprintf("⚠️ [%s] 获取 Zone 信息失败, 按完整域名处理: %v", domain, err))
 	}
+	recordName := relativeRecordName(domain, zoneName)
 
-	// 2. 删除旧记录
-	for _, r := range records {
-		deleteDNSRecord(r)
+	records, err := provider.ListRecords(domain)
+	if err != nil {
+		writeLogStage("dns", "error", fmt.Sprintf("❌ 获取记录失败 [%s]: %v", domain, err))
+		return false
 	}
 
-	// 3. 计算 Record Name (避免双重后缀)
-	// 如果 domain 是 "yx.abc.com" 且 zoneName 是 "abc.com"，则 recordName 应该设为 "yx"
-	// 如果 domain 是 "abc.com" 且 zoneName 是 "abc.com"，则 recordName 应该设为 "@"
-	recordName := domain
-	if zoneName != "" {
-		if domain == zoneName {
-			recordName = "@"
-		} else if strings.HasSuffix(domain, "."+zoneName) {
-			// 移除后缀 .abc.com
-			recordName = strings.TrimSuffix(domain, "."+zoneName)
-		}
+	changes := diffDNSChanges(records, newIPs, recordName)
+	if len(changes) == 0 {
+		writeLogStage("dns", "info", fmt.Sprintf("ℹ️ [%s] 记录与目标一致，无需变更", domain))
+		return true
+	}
+
+	if err := provider.Apply(changes); err != nil {
+		writeLogStage("dns", "error", fmt.Sprintf("❌ 更新记录失败 [%s]: %v", domain, err))
+		return false
+	}
+	writeLogStage("dns", "info", fmt.Sprintf("📡 [%s] 变更完成 (共 %d 项)", domain, len(changes)))
+	return true
+}
+
+// relativeRecordName 把完整域名换算成相对 zoneName 的记录名 (避免双重后缀)
+// 如果 domain 是 "yx.abc.com" 且 zoneName 是 "abc.com"，则结果为 "yx"
+// 如果 domain 是 "abc.com" 且 zoneName 是 "abc.com"，则结果为 "@"
+func relativeRecordName(domain, zoneName string) string {
+	if zoneName == "" {
+		return domain
+	}
+	if domain == zoneName {
+		return "@"
+	}
+	if strings.HasSuffix(domain, "."+zoneName) {
+		return strings.TrimSuffix(domain, "."+zoneName)
 	}
+	return domain
+}
 
-	// 4. 添加新记录
+// diffDNSChanges 按类型 (A/AAAA) 分组现有记录与目标 IP, 求出最小变更集:
+// 优先用 update 原地换血, 其余才 create/delete, 让服务商侧少做一次删除-创建的空窗
+func diffDNSChanges(existing []Record, newIPs []string, recordName string) []Change {
+	existingByType := map[string][]Record{}
+	for _, r := range existing {
+		// 只接管 A/AAAA, 同名下的 TXT/MX/NS 等记录与本工具无关, 不应被当成待删除的残留
+		if r.Type != "A" && r.Type != "AAAA" {
+			continue
+		}
+		existingByType[r.Type] = append(existingByType[r.Type], r)
+	}
+	desiredByType := map[string][]string{}
 	for _, ip := range newIPs {
-		createDNSRecord(domain, recordName, ip)
+		t := "A"
+		if strings.Contains(ip, ":") {
+			t = "AAAA"
+		}
+		desiredByType[t] = append(desiredByType[t], ip)
+	}
+
+	var changes []Change
+	for t, existingOfType := range existingByType {
+		desired := desiredByType[t]
+		remaining := existingOfType
+		var missing []string
+		for _, ip := range desired {
+			found := false
+			for i, r := range remaining {
+				if r.Content == ip {
+					found = true
+					remaining = append(remaining[:i], remaining[i+1:]...)
+					break
+				}
+			}
+			if !found {
+				missing = append(missing, ip)
+			}
+		}
+		for len(remaining) > 0 && len(missing) > 0 {
+			changes = append(changes, Change{Op: ChangeUpdate, ID: remaining[0].ID, Type: t, Name: recordName, Content: missing[0]})
+			remaining = remaining[1:]
+			missing = missing[1:]
+		}
+		for _, r := range remaining {
+			changes = append(changes, Change{Op: ChangeDelete, ID: r.ID, Type: t})
+		}
+		for _, ip := range missing {
+			changes = append(changes, Change{Op: ChangeCreate, Type: t, Name: recordName, Content: ip})
+		}
 	}
+	// desiredByType 里可能存在 existingByType 没有的全新类型 (例如首次下发 AAAA)
+	for t, desired := range desiredByType {
+		if _, ok := existingByType[t]; ok {
+			continue
+		}
+		for _, ip := range desired {
+			changes = append(changes, Change{Op: ChangeCreate, Type: t, Name: recordName, Content: ip})
+		}
+	}
+	return changes
 }
 
 // --- 文件处理辅助 ---
@@ -302,6 +451,33 @@ func parseResultCSV(file string, max int) []string {
 	return ips
 }
 
+// IPMetric 是 cfst 测速结果里某个 IP 的延迟/速度, 供历史趋势记录使用
+type IPMetric struct {
+	Latency float64 `json:"latency"` // 平均延迟 (ms)
+	Speed   float64 `json:"speed"`   // 下载速度 (MB/s)
+}
+
+// parseResultMetrics 解析 cfst 输出的 CSV, 按 IP 取出延迟/速度两列
+// (cfst 的列布局固定为: IP,已发送,已接收,丢包率,平均延迟,下载速度)
+func parseResultMetrics(file string) map[string]IPMetric {
+	f, err := os.Open(file)
+	if err != nil { return nil }
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	records, err := reader.ReadAll()
+	if err != nil { return nil }
+
+	metrics := map[string]IPMetric{}
+	for i, row := range records {
+		if i == 0 || len(row) < 6 { continue }
+		latency, _ := strconv.ParseFloat(row[4], 64)
+		speed, _ := strconv.ParseFloat(row[5], 64)
+		metrics[row[0]] = IPMetric{Latency: latency, Speed: speed}
+	}
+	return metrics
+}
+
 func combineFiles(dst string, src ...string) error {
 	out, err := os.Create(dst)
 	if err != nil { return err }
@@ -317,10 +493,335 @@ func combineFiles(dst string, src ...string) error {
 	return nil
 }
 
+// streamCfstOutput 按行扫描 cfst 的 stdout/stderr, 把每一行都包装进 stage:"speedtest"
+// 的结构化日志, 而不是像以前那样把原始字节直接 io.Copy 进日志文件
+func streamCfstOutput(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		writeLogStage("speedtest", "info", scanner.Text())
+	}
+}
+
+// --- 变更检测 ---
+
+// lastRunState 记录上一次成功运行发布到各域名的 IP, 用于判断线上记录是否发生漂移
+type lastRunState struct {
+	Timestamp time.Time           `json:"timestamp"`
+	IPs       map[string][]string `json:"ips"`
+}
+
+func loadLastRunState() (lastRunState, error) {
+	var state lastRunState
+	f, err := os.Open(lastIPsFile)
+	if err != nil { return state, err }
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(&state); err != nil { return state, err }
+	return state, nil
+}
+
+func saveLastRunState(ips map[string][]string) {
+	state := lastRunState{Timestamp: time.Now(), IPs: ips}
+	f, err := os.Create(lastIPsFile)
+	if err != nil { return }
+	defer f.Close()
+	json.NewEncoder(f).Encode(state)
+}
+
+// skipReason 在启用 SkipUnchanged 时判断本次运行是否可以跳过: 距离上次运行未超过
+// MinRerunInterval, 且每个域名当前解析的 IP 与上次发布的集合完全一致 (无人工改动)。
+// 返回空字符串表示应当照常执行, 否则返回值即为写入日志的跳过原因。
+func skipReason(provider DNSProvider, domains []string) string {
+	if !config.SkipUnchanged {
+		return ""
+	}
+	interval, err := time.ParseDuration(config.MinRerunInterval)
+	if err != nil || interval <= 0 {
+		return ""
+	}
+	state, err := loadLastRunState()
+	if err != nil {
+		return "" // 没有历史记录可比对，照常执行
+	}
+	if time.Since(state.Timestamp) >= interval {
+		return ""
+	}
+	if provider == nil {
+		return "" // 无法核对线上记录，保守起见照常执行
+	}
+	for _, domain := range domains {
+		records, err := provider.ListRecords(domain)
+		if err != nil {
+			return "" // 查询失败时保守执行，不跳过
+		}
+		var current []string
+		for _, r := range records {
+			if r.Type != "A" && r.Type != "AAAA" {
+				continue
+			}
+			current = append(current, r.Content)
+		}
+		if !sameIPSet(current, state.IPs[domain]) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("距上次运行未超过 %s 且解析记录无变化", config.MinRerunInterval)
+}
+
+// sameIPSet 忽略顺序比较两组 IP 是否完全相同
+func sameIPSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// --- 历史记录 ---
+
+// HistoryEntry 是 history.jsonl 里的一行, 记录某次运行下发的域名->IP 映射以及
+// 对应 IP 在本次测速中的延迟/速度, 供前端画趋势图
+type HistoryEntry struct {
+	Timestamp time.Time           `json:"timestamp"`
+	Domains   map[string][]string `json:"domains"`
+	Metrics   map[string]IPMetric `json:"metrics"`
+}
+
+// appendHistoryEntry 把本次发布结果追加进 history.jsonl, 随后按保留天数清理旧数据
+func appendHistoryEntry(published map[string][]string, resultFile string) {
+	allMetrics := parseResultMetrics(resultFile)
+	metrics := map[string]IPMetric{}
+	for _, ips := range published {
+		for _, ip := range ips {
+			if m, ok := allMetrics[ip]; ok {
+				metrics[ip] = m
+			}
+		}
+	}
+
+	entry := HistoryEntry{Timestamp: time.Now(), Domains: published, Metrics: metrics}
+	body, err := json.Marshal(entry)
+	if err != nil { return }
+
+	f, err := os.OpenFile(historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil { return }
+	f.Write(append(body, '\n'))
+	f.Close()
+
+	trimHistory(config.HistoryKeepDays)
+}
+
+// loadHistoryEntries 按写入顺序 (从旧到新) 读出全部历史记录
+func loadHistoryEntries() []HistoryEntry {
+	data, err := os.ReadFile(historyFile)
+	if err != nil { return nil }
+
+	var entries []HistoryEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" { continue }
+		var e HistoryEntry
+		if err := json.Unmarshal([]byte(line), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// trimHistory 丢弃超过 keepDays 天的历史记录; keepDays<=0 表示不清理
+func trimHistory(keepDays int) {
+	if keepDays <= 0 { return }
+	entries := loadHistoryEntries()
+	if entries == nil { return }
+
+	cutoff := time.Now().AddDate(0, 0, -keepDays)
+	var lines []string
+	for _, e := range entries {
+		if e.Timestamp.After(cutoff) {
+			body, err := json.Marshal(e)
+			if err != nil { continue }
+			lines = append(lines, string(body))
+		}
+	}
+	os.WriteFile(historyFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// --- TLS/SNI 校验 ---
+
+// defaultCloudflareSANs 是官方常见的 Cloudflare 证书域名, 无需用户配置即可匹配
+var defaultCloudflareSANs = []string{"*.cloudflare-dns.com", "*.cloudflare.com"}
+
+// filterCloudflareIPs 并发对每个候选 IP 做 TLS 握手, 剔除证书不属于 Cloudflare 的 IP
+// (常见于反代扫描场景: 443 端口开着但实际指向别人的站点)
+func filterCloudflareIPs(ips []string, port int) []string {
+	timeout := time.Duration(config.TLSTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	const workers = 8
+	jobs := make(chan string)
+	results := make(chan string, len(ips))
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ip := range jobs {
+				if verifyCloudflareCert(ip, port, timeout) {
+					results <- ip
+				}
+			}
+		}()
+	}
+
+	for _, ip := range ips {
+		jobs <- ip
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+
+	var verified []string
+	for ip := range results {
+		verified = append(verified, ip)
+	}
+	return verified
+}
+
+// verifyCloudflareCert 对单个 IP 在 TestPort 上发起 TLS 握手, 检查证书 SAN 或颁发者 CN
+// 是否与已知/用户配置的 Cloudflare 标识匹配
+func verifyCloudflareCert(ip string, port int, timeout time.Duration) bool {
+	dialer := &net.Dialer{Timeout: timeout}
+	addr := net.JoinHostPort(ip, fmt.Sprintf("%d", port))
+
+	sniHost := "cloudflare-dns.com"
+	if sans := config.TLSExpectedSANs; len(sans) > 0 {
+		sniHost = strings.TrimPrefix(sans[0], "*.")
+	}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{
+		ServerName:         sniHost,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return false
+	}
+	cert := certs[0]
+
+	allowed := append(append([]string{}, defaultCloudflareSANs...), config.TLSExpectedSANs...)
+	for _, san := range cert.DNSNames {
+		if matchesAny(san, allowed) {
+			return true
+		}
+	}
+	if matchesAny(cert.Issuer.CommonName, allowed) {
+		return true
+	}
+	return false
+}
+
+// matchesAny 支持 "*.example.com" 通配符前缀匹配, 否则按原样比较
+func matchesAny(value string, patterns []string) bool {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "*.") {
+			suffix := strings.TrimPrefix(p, "*")
+			if strings.HasSuffix(value, suffix) {
+				return true
+			}
+		} else if value == p {
+			return true
+		}
+	}
+	return false
+}
+
+// --- DNS 服务商抽象 ---
+
+// Record 是某条 DNS 记录的服务商无关表示, Name 是相对 zone 的记录名 ("@" 或 "yx");
+// zone 名未知或域名不在该 zone 下时为完整域名
+type Record struct {
+	ID      string
+	Type    string
+	Name    string
+	Content string
+	TTL     int
+	Proxied bool
+}
+
+// ChangeOp 描述一次记录变更的动作
+type ChangeOp string
+
+const (
+	ChangeCreate ChangeOp = "create"
+	ChangeUpdate ChangeOp = "update"
+	ChangeDelete ChangeOp = "delete"
+)
+
+// Change 是 diffDNSChanges 求出的最小变更项, Update/Delete 时 ID 必填
+type Change struct {
+	Op      ChangeOp
+	ID      string
+	Type    string
+	Name    string
+	Content string
+}
+
+// DNSProvider 抽象不同 DNS 服务商的读写能力, 让优选 IP 下发流程与具体厂商解耦,
+// 自建权威 DNS 的用户也能复用除 API 调用之外的整套 cfst 优选-分发管线
+type DNSProvider interface {
+	ZoneName() (string, error)
+	ListRecords(domain string) ([]Record, error)
+	Apply(changes []Change) error
+}
+
+// newDNSProvider 按配置构造对应的 Provider 实现
+func newDNSProvider() DNSProvider {
+	if config.Provider == "dnspod" {
+		return &DNSPodProvider{}
+	}
+	return &CloudflareProvider{}
+}
+
+// hasDNSCredentials 判断当前所选服务商的凭据是否填写完整, 不完整时直接跳过 DNS 更新
+func hasDNSCredentials() bool {
+	if config.ZoneID == "" {
+		return false
+	}
+	if config.Provider == "dnspod" {
+		return config.DNSPodID != "" && config.DNSPodToken != ""
+	}
+	if config.AuthMode == "token" {
+		return config.APIToken != ""
+	}
+	return config.APIKey != ""
+}
+
 // --- Cloudflare API ---
 
-// 新增: 获取 Zone 真实名称 (如 abc.com)
-func fetchZoneName() (string, error) {
+// CloudflareProvider 是 DNSProvider 的默认实现, zoneName 在首次 ZoneName() 调用后缓存，
+// 避免同一轮运行里对多个域名重复请求 Zone 信息
+type CloudflareProvider struct {
+	zoneName string
+}
+
+func (p *CloudflareProvider) ZoneName() (string, error) {
+	if p.zoneName != "" {
+		return p.zoneName, nil
+	}
 	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s", config.ZoneID)
 	req, _ := http.NewRequest("GET", url, nil)
 	setHeaders(req)
@@ -334,10 +835,21 @@ func fetchZoneName() (string, error) {
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil { return "", err }
 	if !res.Success { return "", fmt.Errorf("zone fetch failed") }
-	return res.Result.Name, nil
+	p.zoneName = res.Result.Name
+	return p.zoneName, nil
+}
+
+// cfRecord 是 Cloudflare API 原始返回的记录结构
+type cfRecord struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+	Proxied bool   `json:"proxied"`
 }
 
-func getDNSRecords(domain string) ([]string, error) {
+func (p *CloudflareProvider) ListRecords(domain string) ([]Record, error) {
 	// 查询时使用完整域名 (FQDN) 是最准确的
 	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records?name=%s", config.ZoneID, domain)
 	req, _ := http.NewRequest("GET", url, nil)
@@ -348,47 +860,255 @@ func getDNSRecords(domain string) ([]string, error) {
 
 	var res struct {
 		Success bool `json:"success"`
-		Result []struct { ID string `json:"id"` } `json:"result"`
+		Result []cfRecord `json:"result"`
 		Errors []interface{} `json:"errors"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil { return nil, err }
 	if !res.Success { return nil, fmt.Errorf("api error: %v", res.Errors) }
-	
-	var ids []string
-	for _, r := range res.Result { ids = append(ids, r.ID) }
-	return ids, nil
-}
 
-func deleteDNSRecord(id string) {
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", config.ZoneID, id)
-	req, _ := http.NewRequest("DELETE", url, nil)
-	setHeaders(req)
-	http.DefaultClient.Do(req)
+	recordName := relativeRecordName(domain, p.zoneName)
+	var records []Record
+	for _, r := range res.Result {
+		records = append(records, Record{ID: r.ID, Type: r.Type, Name: recordName, Content: r.Content, TTL: r.TTL, Proxied: r.Proxied})
+	}
+	return records, nil
 }
 
-// 修改: 接受 recordName 用于创建
-func createDNSRecord(fullDomain, recordName, ip string) {
-	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", config.ZoneID)
-	typeStr := "A"
-	if strings.Contains(ip, ":") { typeStr = "AAAA" }
-	
-	// payload 中使用 recordName (例如 "yx" 或 "@")
-	payload := map[string]interface{}{
-		"type": typeStr, "name": recordName, "content": ip, "ttl": 60, "proxied": false,
+// Apply 把变更集按类型拆分, 只有一项时直接单独调用, 多项时走 /dns_records/batch
+// 让记录替换在解析器看来是一次原子操作, 而不是先删除再创建造成的解析空窗
+func (p *CloudflareProvider) Apply(changes []Change) error {
+	if len(changes) == 1 {
+		return p.applyOne(changes[0])
 	}
+
+	url := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/batch", config.ZoneID)
+	var deletes, patches, posts []map[string]interface{}
+	for _, c := range changes {
+		switch c.Op {
+		case ChangeDelete:
+			deletes = append(deletes, map[string]interface{}{"id": c.ID})
+		case ChangeUpdate:
+			patches = append(patches, map[string]interface{}{"id": c.ID, "content": c.Content})
+		case ChangeCreate:
+			posts = append(posts, map[string]interface{}{
+				"type": c.Type, "name": c.Name, "content": c.Content, "ttl": 60, "proxied": false,
+			})
+		}
+	}
+
+	payload := map[string]interface{}{"deletes": deletes, "patches": patches, "posts": posts}
 	body, _ := json.Marshal(payload)
 	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(body))
 	setHeaders(req)
 	resp, err := http.DefaultClient.Do(req)
-	if err == nil { defer resp.Body.Close() }
+	if err != nil { return err }
+	defer resp.Body.Close()
+
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []interface{} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil { return err }
+	if !res.Success { return fmt.Errorf("batch api error: %v", res.Errors) }
+	return nil
+}
+
+func (p *CloudflareProvider) applyOne(c Change) error {
+	var (
+		method string
+		url    string
+		body   []byte
+	)
+	switch c.Op {
+	case ChangeDelete:
+		method = "DELETE"
+		url = fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", config.ZoneID, c.ID)
+	case ChangeUpdate:
+		method = "PATCH"
+		url = fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records/%s", config.ZoneID, c.ID)
+		body, _ = json.Marshal(map[string]interface{}{"content": c.Content})
+	case ChangeCreate:
+		method = "POST"
+		url = fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/dns_records", config.ZoneID)
+		body, _ = json.Marshal(map[string]interface{}{
+			"type": c.Type, "name": c.Name, "content": c.Content, "ttl": 60, "proxied": false,
+		})
+	default:
+		return nil
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewBuffer(body)
+	}
+	req, _ := http.NewRequest(method, url, bodyReader)
+	setHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Success bool          `json:"success"`
+		Errors  []interface{} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.Success {
+		return fmt.Errorf("api error: %v", res.Errors)
+	}
+	return nil
+}
+
+// --- DNSPod API ---
+// DNSPod 是国内用户常用的自建权威 DNS 服务商之一, 接口走 dnsapi.cn 的 token 鉴权 + 表单请求。
+// 注意: ZoneID 字段在 DNSPod 模式下存放的是根域名本身 (如 "abc.com")，而非 Cloudflare 的 Zone ID。
+type DNSPodProvider struct {
+	zoneName string
+}
+
+func (p *DNSPodProvider) ZoneName() (string, error) {
+	p.zoneName = config.ZoneID
+	return p.zoneName, nil
+}
+
+func (p *DNSPodProvider) loginParams() url.Values {
+	v := url.Values{}
+	v.Set("login_token", config.DNSPodID+","+config.DNSPodToken)
+	v.Set("format", "json")
+	return v
+}
+
+func (p *DNSPodProvider) ListRecords(domain string) ([]Record, error) {
+	zoneName, _ := p.ZoneName()
+	recordName := relativeRecordName(domain, zoneName)
+
+	v := p.loginParams()
+	v.Set("domain", zoneName)
+	v.Set("sub_domain", recordName)
+	resp, err := http.PostForm("https://dnsapi.cn/Record.List", v)
+	if err != nil { return nil, err }
+	defer resp.Body.Close()
+
+	var res struct {
+		Status struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"status"`
+		Records []struct {
+			ID    string `json:"id"`
+			Type  string `json:"type"`
+			Value string `json:"value"`
+			TTL   string `json:"ttl"`
+		} `json:"records"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil { return nil, err }
+	if res.Status.Code == "8" {
+		return nil, nil // "域名记录不存在", 等同于空列表
+	}
+	if res.Status.Code != "1" {
+		return nil, fmt.Errorf("dnspod api error: %s", res.Status.Message)
+	}
+
+	var records []Record
+	for _, r := range res.Records {
+		ttl, _ := strconv.Atoi(r.TTL)
+		records = append(records, Record{ID: r.ID, Type: r.Type, Name: recordName, Content: r.Value, TTL: ttl})
+	}
+	return records, nil
+}
+
+func (p *DNSPodProvider) Apply(changes []Change) error {
+	zoneName, _ := p.ZoneName()
+	// DNSPod 没有批量接口, 逐条按 create/modify/remove 下发即可
+	for _, c := range changes {
+		v := p.loginParams()
+		v.Set("domain", zoneName)
+		var apiURL string
+		switch c.Op {
+		case ChangeDelete:
+			v.Set("record_id", c.ID)
+			apiURL = "https://dnsapi.cn/Record.Remove"
+		case ChangeUpdate:
+			v.Set("record_id", c.ID)
+			v.Set("sub_domain", c.Name)
+			v.Set("record_type", c.Type)
+			v.Set("record_line", "默认")
+			v.Set("value", c.Content)
+			apiURL = "https://dnsapi.cn/Record.Modify"
+		case ChangeCreate:
+			v.Set("sub_domain", c.Name)
+			v.Set("record_type", c.Type)
+			v.Set("record_line", "默认")
+			v.Set("value", c.Content)
+			apiURL = "https://dnsapi.cn/Record.Create"
+		}
+
+		resp, err := http.PostForm(apiURL, v)
+		if err != nil {
+			return fmt.Errorf("dnspod %s 失败: %w", c.Op, err)
+		}
+		var res struct {
+			Status struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"status"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("dnspod %s 响应解析失败: %w", c.Op, err)
+		}
+		if res.Status.Code != "1" {
+			return fmt.Errorf("dnspod %s 失败: %s", c.Op, res.Status.Message)
+		}
+	}
+	return nil
 }
 
 func setHeaders(req *http.Request) {
-	req.Header.Set("X-Auth-Email", config.Email)
-	req.Header.Set("X-Auth-Key", config.APIKey)
+	if config.AuthMode == "token" {
+		req.Header.Set("Authorization", "Bearer "+config.APIToken)
+	} else {
+		req.Header.Set("X-Auth-Email", config.Email)
+		req.Header.Set("X-Auth-Key", config.APIKey)
+	}
 	req.Header.Set("Content-Type", "application/json")
 }
 
+// verifyAPIToken 在 token 模式下调用 Cloudflare 的校验接口, 把 Token 是否有效及其状态
+// 写入日志, 避免记录增删接口静默失败导致用户排查困难。
+// 只校验 Token 本身; 不检查其作用域是否覆盖所配置的 ZoneID。
+func verifyAPIToken() {
+	req, _ := http.NewRequest("GET", "https://api.cloudflare.com/client/v4/user/tokens/verify", nil)
+	setHeaders(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		writeLog(fmt.Sprintf("⚠️ API Token 校验请求失败: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Success bool `json:"success"`
+		Result  struct {
+			Status string `json:"status"`
+		} `json:"result"`
+		Errors []interface{} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		writeLog(fmt.Sprintf("⚠️ API Token 校验响应解析失败: %v", err))
+		return
+	}
+	if !res.Success {
+		writeLog(fmt.Sprintf("❌ API Token 无效或权限不足 (请确认其覆盖 Zone ID: %s): %v", config.ZoneID, res.Errors))
+		return
+	}
+	writeLog(fmt.Sprintf("✅ API Token 校验通过 (status: %s)", res.Result.Status))
+}
+
 // --- 日志与文件 ---
 
 type LogWriter struct{}
@@ -396,17 +1116,52 @@ func (l LogWriter) Write(p []byte) (n int, err error) {
 	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil { return 0, err }
 	defer f.Close()
-	fmt.Print(string(p)) 
+	fmt.Print(string(p))
 	return f.Write(p)
 }
 func getLogWriter() io.Writer { return LogWriter{} }
 
+// logEntry 是结构化日志的 JSON 形态, 写入 app.jsonl 供前端按 stage/level 过滤
+type logEntry struct {
+	Ts    string `json:"ts"`
+	Level string `json:"level"`
+	Stage string `json:"stage"`
+	Msg   string `json:"msg"`
+}
+
+// writeLog 是历史上各处调用的通用日志入口, stage 固定为 "general"
 func writeLog(msg string) {
-	ts := time.Now().Format("2006-01-02 15:04:05")
-	line := fmt.Sprintf("[%s] %s\n", ts, msg)
+	writeLogStage("general", inferLevel(msg), msg)
+}
+
+// writeLogStage 同时写入人类可读的 app.log 和结构化的 app.jsonl (line-delimited JSON)
+func writeLogStage(stage, level, msg string) {
+	now := time.Now()
+	line := fmt.Sprintf("[%s] %s\n", now.Format("2006-01-02 15:04:05"), msg)
 	getLogWriter().Write([]byte(line))
+
+	entry := logEntry{Ts: now.Format(time.RFC3339), Level: level, Stage: stage, Msg: msg}
+	body, err := json.Marshal(entry)
+	if err != nil { return }
+	f, err := os.OpenFile(logJSONFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil { return }
+	defer f.Close()
+	f.Write(append(body, '\n'))
+}
+
+// inferLevel 根据 writeLog 一直沿用的表情符号前缀推断日志级别
+func inferLevel(msg string) string {
+	switch {
+	case strings.HasPrefix(msg, "❌"):
+		return "error"
+	case strings.HasPrefix(msg, "⚠️"):
+		return "warn"
+	default:
+		return "info"
+	}
 }
 
+// handleLogs 是基于 offset 轮询的旧接口, 为兼容老前端继续保留
 func handleLogs(w http.ResponseWriter, r *http.Request) {
 	offsetStr := r.URL.Query().Get("offset")
 	offset, _ := strconv.ParseInt(offsetStr, 10, 64)
@@ -427,6 +1182,61 @@ func handleLogs(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleLogsStream 用 SSE 推送 app.jsonl 的增量内容, 用 fsnotify 监听文件写入事件
+// 而不是像 handleLogs 那样轮询重读; 推送结构化 JSON 而非 app.log 的人类可读文本,
+// 这样前端才能真正按 stage/level 过滤
+func handleLogsStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Open(logJSONFile)
+	if err != nil {
+		http.Error(w, "log not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+	f.Seek(0, io.SeekEnd) // 只推送连接建立之后新增的内容
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		http.Error(w, "watcher init failed", http.StatusInternalServerError)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(logJSONFile); err != nil {
+		http.Error(w, "watch log failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	reader := bufio.NewReader(f)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok { return }
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 { continue }
+			for {
+				line, err := reader.ReadString('\n')
+				if line != "" {
+					fmt.Fprintf(w, "data: %s\n\n", strings.TrimRight(line, "\n"))
+					flusher.Flush()
+				}
+				if err != nil { break }
+			}
+		case _, ok := <-watcher.Errors:
+			if !ok { return }
+		}
+	}
+}
+
 // --- Web Handlers ---
 
 func handleSave(w http.ResponseWriter, r *http.Request) {
@@ -437,17 +1247,34 @@ func handleSave(w http.ResponseWriter, r *http.Request) {
 	config.APIKey = r.FormValue("api_key")
 	config.Email = r.FormValue("email")
 	config.Domains = r.FormValue("domains")
+	config.AuthMode = r.FormValue("auth_mode")
+	if config.AuthMode == "" {
+		config.AuthMode = "global_key"
+	}
+	config.APIToken = r.FormValue("api_token")
+	config.Provider = r.FormValue("provider")
+	if config.Provider == "" {
+		config.Provider = "cloudflare"
+	}
+	config.DNSPodID = r.FormValue("dnspod_id")
+	config.DNSPodToken = r.FormValue("dnspod_token")
 	config.DownloadURL = r.FormValue("download_url")
 	config.IPType = r.FormValue("ip_type")
 	config.Colo = strings.ToUpper(r.FormValue("colo"))
 	config.EnableHTTPing = (r.FormValue("enable_httping") == "on")
-	
+	config.EnableTLSValidation = (r.FormValue("enable_tls_validation") == "on")
+	config.TLSExpectedSANs = parseDomains(r.FormValue("tls_expected_sans"))
+	config.SkipUnchanged = (r.FormValue("skip_unchanged") == "on")
+	config.MinRerunInterval = r.FormValue("min_rerun_interval")
+
 	fmt.Sscanf(r.FormValue("test_count"), "%d", &config.TestCount)
 	fmt.Sscanf(r.FormValue("max_result"), "%d", &config.MaxResult)
 	fmt.Sscanf(r.FormValue("min_speed"), "%f", &config.MinSpeed)
 	fmt.Sscanf(r.FormValue("max_delay"), "%d", &config.MaxDelay)
 	fmt.Sscanf(r.FormValue("min_delay"), "%d", &config.MinDelay)
 	fmt.Sscanf(r.FormValue("test_port"), "%d", &config.TestPort)
+	fmt.Sscanf(r.FormValue("tls_timeout_sec"), "%d", &config.TLSTimeoutSec)
+	fmt.Sscanf(r.FormValue("history_keep_days"), "%d", &config.HistoryKeepDays)
 
 	saveConfig()
 	updateCron()
@@ -481,6 +1308,54 @@ func handleStatus(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// handleHistory 返回最近 N 条运行记录 (最新的在前), 可选按域名过滤
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	domain := r.URL.Query().Get("domain")
+
+	entries := loadHistoryEntries()
+	var result []HistoryEntry
+	for i := len(entries) - 1; i >= 0 && len(result) < limit; i-- {
+		e := entries[i]
+		if domain != "" {
+			if _, ok := e.Domains[domain]; !ok { continue }
+		}
+		result = append(result, e)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleHistoryMetrics 返回某个 IP 的延迟/速度时间序列, 供前端画趋势图
+func handleHistoryMetrics(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	if ip == "" {
+		http.Error(w, "missing ip", http.StatusBadRequest)
+		return
+	}
+
+	type point struct {
+		Timestamp time.Time `json:"timestamp"`
+		Latency   float64   `json:"latency"`
+		Speed     float64   `json:"speed"`
+	}
+	var series []point
+	for _, e := range loadHistoryEntries() {
+		if m, ok := e.Metrics[ip]; ok {
+			series = append(series, point{Timestamp: e.Timestamp, Latency: m.Latency, Speed: m.Speed})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
 func handleIndex(w http.ResponseWriter, r *http.Request) {
 	tmpl, _ := template.ParseFiles("index.html")
 	mutex.Lock()
@@ -497,17 +1372,32 @@ func handleRunNow(w http.ResponseWriter, r *http.Request) {
 
 func loadConfig() {
 	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		config = Config{CronSpec: "0 * * * *", TestCount: 10, MaxResult: 10, IPType: "v4", TestPort: 443}
+		config = Config{CronSpec: "0 * * * *", TestCount: 10, MaxResult: 10, IPType: "v4", TestPort: 443, AuthMode: "global_key", Provider: "cloudflare", HistoryKeepDays: 30}
 		return
 	}
-	f, _ := os.Open(configFile)
-	json.NewDecoder(f).Decode(&config)
-	f.Close()
+	raw, _ := os.ReadFile(configFile)
+	json.Unmarshal(raw, &config)
+	if config.AuthMode == "" {
+		config.AuthMode = "global_key"
+	}
+	if config.Provider == "" {
+		config.Provider = "cloudflare"
+	}
+	// history_keep_days 的 0 是 "永不清理" 的合法取值 (见字段注释), 只有老配置文件里
+	// 压根没有这个字段时才补默认值, 不能像 AuthMode/Provider 那样直接按零值判断
+	var rawFields map[string]interface{}
+	json.Unmarshal(raw, &rawFields)
+	if _, ok := rawFields["history_keep_days"]; !ok {
+		config.HistoryKeepDays = 30
+	}
 }
 func saveConfig() { f, _ := os.Create(configFile); json.NewEncoder(f).Encode(config); f.Close() }
 func updateCron() {
 	if len(cronRunner.Entries()) > 0 { cronRunner = cron.New(); cronRunner.Start() }
-	cronRunner.AddFunc(config.CronSpec, func() { go runSpeedTestAndUpdateDNS() })
+	cronRunner.AddFunc(config.CronSpec, func() {
+		writeLogStage("cron", "info", "⏰ Cron 触发任务")
+		go runSpeedTestAndUpdateDNS()
+	})
 }
 func fileExists(f string) bool { _, e := os.Stat(f); return !os.IsNotExist(e) }
 func sliceContains(s []string, e string) bool { for _, a := range s { if a == e { return true } }; return false }